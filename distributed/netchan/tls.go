@@ -0,0 +1,39 @@
+// Package netchan carries gleam's agent<->master and agent<->agent
+// shuffle traffic. This file adds an optional mutual-TLS transport on top
+// of the plain net.Listener/net.Conn path the rest of the package uses.
+package netchan
+
+import (
+	"crypto/tls"
+	"net"
+
+	"github.com/apache/thrift/lib/go/thrift"
+)
+
+// ListenTLS wraps a plain TCP listener on address with cfg, so every
+// accepted connection is already mutually authenticated. cfg is expected
+// to have ClientAuth set to tls.RequireAndVerifyClientCert, e.g. via
+// security.Config.ServerTLSConfig.
+func ListenTLS(address string, cfg *tls.Config) (net.Listener, error) {
+	ln, err := net.Listen("tcp", address)
+	if err != nil {
+		return nil, err
+	}
+	return tls.NewListener(ln, cfg), nil
+}
+
+// DialTLS connects to address over mTLS. It dials through the vendored
+// thrift TSSLSocket rather than crypto/tls directly, so gleam's RPC framing
+// (built on the thrift transports) and the shuffle path share one
+// TLS-dialing code path. cfg.ServerName should be set for SNI and hostname
+// verification.
+func DialTLS(address string, cfg *tls.Config) (net.Conn, error) {
+	socket, err := thrift.NewTSSLSocket(address, cfg)
+	if err != nil {
+		return nil, err
+	}
+	if err := socket.Open(); err != nil {
+		return nil, err
+	}
+	return socket.Conn(), nil
+}
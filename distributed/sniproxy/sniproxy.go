@@ -0,0 +1,165 @@
+// Package sniproxy multiplexes several logical services behind a single
+// listening port by peeking the TLS ClientHello of each incoming
+// connection and dispatching on its SNI hostname, without terminating TLS
+// itself. This lets operators front all of an agent's traffic (shuffle,
+// control RPC, status) on one port behind an L4 load balancer, with each
+// service still doing its own TLS handshake and presenting its own
+// certificate.
+//
+// Nothing in this tree wires Proxy into an actual agent listener yet — the
+// agent's listen loop and service registration live in a distributed/agent
+// package, which this checkout doesn't have. This package is the standalone
+// dispatch mechanism described above; plugging it into a real agent is
+// follow-up work once that package exists.
+package sniproxy
+
+import (
+	"bytes"
+	"crypto/tls"
+	"errors"
+	"net"
+	"sync"
+	"time"
+)
+
+// Handler takes over a connection once its SNI hostname has been matched.
+// It sees exactly the bytes the client sent, including the ClientHello, so
+// it can perform its own TLS handshake (or anything else) as if it had
+// accepted the connection directly.
+type Handler func(conn net.Conn)
+
+// Proxy dispatches accepted connections to a Handler registered for the
+// connection's SNI hostname.
+type Proxy struct {
+	mu       sync.RWMutex
+	handlers map[string]Handler
+
+	// PeekTimeout bounds how long Proxy waits for a ClientHello before
+	// giving up on a connection. Zero means no deadline.
+	PeekTimeout time.Duration
+}
+
+// New creates an empty Proxy. Register handlers with Handle before calling Serve.
+func New() *Proxy {
+	return &Proxy{
+		handlers:    make(map[string]Handler),
+		PeekTimeout: 10 * time.Second,
+	}
+}
+
+// Handle registers handler to take over connections whose ClientHello
+// requests hostname via SNI.
+func (p *Proxy) Handle(hostname string, handler Handler) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.handlers[hostname] = handler
+}
+
+// Serve accepts connections from ln until it returns an error, dispatching
+// each to the handler matching its SNI hostname. Unmatched or malformed
+// connections are closed.
+func (p *Proxy) Serve(ln net.Listener) error {
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			return err
+		}
+		go p.handleConn(conn)
+	}
+}
+
+func (p *Proxy) handleConn(conn net.Conn) {
+	if p.PeekTimeout > 0 {
+		conn.SetReadDeadline(time.Now().Add(p.PeekTimeout))
+	}
+	serverName, peeked, err := peekClientHelloServerName(conn)
+	if p.PeekTimeout > 0 {
+		conn.SetReadDeadline(time.Time{})
+	}
+	if err != nil {
+		conn.Close()
+		return
+	}
+
+	p.mu.RLock()
+	handler, ok := p.handlers[serverName]
+	p.mu.RUnlock()
+	if !ok {
+		conn.Close()
+		return
+	}
+	handler(newReplayConn(conn, peeked))
+}
+
+var errClientHelloNotSent = errors.New("sniproxy: connection closed before a ClientHello was received")
+
+// peekClientHelloServerName reads just enough of conn to learn the SNI
+// hostname from its ClientHello, then returns the exact bytes it consumed
+// so the caller can replay them ahead of the rest of the connection. It
+// never completes a TLS handshake and never writes to conn.
+func peekClientHelloServerName(conn net.Conn) (serverName string, peeked []byte, err error) {
+	rec := &recordingConn{Conn: conn, buf: new(bytes.Buffer)}
+
+	sawHello := false
+	cfg := &tls.Config{
+		GetConfigForClient: func(hello *tls.ClientHelloInfo) (*tls.Config, error) {
+			serverName = hello.ServerName
+			sawHello = true
+			// Returning an error here stops the handshake immediately,
+			// before tls.Server ever picks a certificate or writes a
+			// ServerHello, so the client's TLS handshake is untouched.
+			return nil, errStopAfterClientHello
+		},
+	}
+
+	handshakeErr := tls.Server(rec, cfg).Handshake()
+	if !sawHello {
+		if handshakeErr == nil {
+			handshakeErr = errClientHelloNotSent
+		}
+		return "", nil, handshakeErr
+	}
+	return serverName, rec.buf.Bytes(), nil
+}
+
+var errStopAfterClientHello = errors.New("sniproxy: stopping handshake after reading ClientHello")
+
+// recordingConn records every byte read from the underlying connection,
+// and discards writes, so a throwaway TLS handshake can be driven against
+// it purely to observe the ClientHello without ever touching the wire on
+// the write side.
+type recordingConn struct {
+	net.Conn
+	buf *bytes.Buffer
+}
+
+func (c *recordingConn) Read(p []byte) (int, error) {
+	n, err := c.Conn.Read(p)
+	if n > 0 {
+		c.buf.Write(p[:n])
+	}
+	return n, err
+}
+
+func (c *recordingConn) Write(p []byte) (int, error) {
+	return len(p), nil
+}
+
+// replayConn replays previously-peeked bytes before falling through to the
+// underlying connection, so a Handler sees the same byte stream it would
+// have seen had it accepted the connection directly.
+type replayConn struct {
+	net.Conn
+	peeked *bytes.Reader
+}
+
+func newReplayConn(conn net.Conn, peeked []byte) net.Conn {
+	return &replayConn{Conn: conn, peeked: bytes.NewReader(peeked)}
+}
+
+func (c *replayConn) Read(p []byte) (int, error) {
+	if c.peeked.Len() > 0 {
+		return c.peeked.Read(p)
+	}
+	return c.Conn.Read(p)
+}
@@ -0,0 +1,126 @@
+package sniproxy
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"io"
+	"math/big"
+	"net"
+	"testing"
+	"time"
+)
+
+func generateSelfSignedCert(t *testing.T, hostname string) tls.Certificate {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("failed to generate key: %v", err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: hostname},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		DNSNames:     []string{hostname},
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("failed to create certificate: %v", err)
+	}
+	return tls.Certificate{
+		Certificate: [][]byte{der},
+		PrivateKey:  key,
+	}
+}
+
+// TestProxyDispatchesBySNI registers two handlers, each terminating TLS
+// with its own certificate, and checks that a client connecting with a
+// given SNI hostname is routed to the matching one.
+func TestProxyDispatchesBySNI(t *testing.T) {
+	shuffleCert := generateSelfSignedCert(t, "shuffle.gleam")
+	controlCert := generateSelfSignedCert(t, "control.gleam")
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	defer ln.Close()
+
+	proxy := New()
+	proxy.Handle("shuffle.gleam", func(conn net.Conn) {
+		defer conn.Close()
+		tlsConn := tls.Server(conn, &tls.Config{Certificates: []tls.Certificate{shuffleCert}})
+		if err := tlsConn.Handshake(); err != nil {
+			return
+		}
+		io.WriteString(tlsConn, "shuffle")
+	})
+	proxy.Handle("control.gleam", func(conn net.Conn) {
+		defer conn.Close()
+		tlsConn := tls.Server(conn, &tls.Config{Certificates: []tls.Certificate{controlCert}})
+		if err := tlsConn.Handshake(); err != nil {
+			return
+		}
+		io.WriteString(tlsConn, "control")
+	})
+
+	go proxy.Serve(ln)
+
+	dial := func(sni string) string {
+		conn, err := tls.Dial("tcp", ln.Addr().String(), &tls.Config{
+			ServerName:         sni,
+			InsecureSkipVerify: true,
+		})
+		if err != nil {
+			t.Fatalf("failed to dial %s: %v", sni, err)
+		}
+		defer conn.Close()
+		buf := make([]byte, 16)
+		n, err := conn.Read(buf)
+		if err != nil && err != io.EOF {
+			t.Fatalf("failed to read response for %s: %v", sni, err)
+		}
+		return string(buf[:n])
+	}
+
+	if got := dial("shuffle.gleam"); got != "shuffle" {
+		t.Fatalf("expected shuffle.gleam to be routed to the shuffle handler, got %q", got)
+	}
+	if got := dial("control.gleam"); got != "control" {
+		t.Fatalf("expected control.gleam to be routed to the control handler, got %q", got)
+	}
+}
+
+func TestProxyClosesUnmatchedHostname(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	defer ln.Close()
+
+	proxy := New()
+	proxy.Handle("shuffle.gleam", func(conn net.Conn) {
+		conn.Close()
+	})
+	go proxy.Serve(ln)
+
+	conn, err := tls.Dial("tcp", ln.Addr().String(), &tls.Config{
+		ServerName:         "unknown.gleam",
+		InsecureSkipVerify: true,
+	})
+	if err == nil {
+		buf := make([]byte, 1)
+		_, readErr := conn.Read(buf)
+		conn.Close()
+		if readErr == nil {
+			t.Fatal("expected the connection for an unregistered hostname to be closed")
+		}
+		return
+	}
+}
@@ -0,0 +1,27 @@
+package flow
+
+import "github.com/chrislusf/gleam/instruction"
+
+// JoinType selects the matching semantics for HashJoin. It mirrors
+// instruction.JoinType so callers of the flow DSL do not need to import the
+// instruction package directly.
+type JoinType instruction.JoinType
+
+const (
+	InnerJoin      = JoinType(instruction.InnerJoin)
+	LeftOuterJoin  = JoinType(instruction.LeftOuterJoin)
+	RightOuterJoin = JoinType(instruction.RightOuterJoin)
+	FullOuterJoin  = JoinType(instruction.FullOuterJoin)
+	LeftSemiJoin   = JoinType(instruction.LeftSemiJoin)
+	LeftAntiJoin   = JoinType(instruction.LeftAntiJoin)
+)
+
+// HashJoin joins this Dataset with other on the given field indexes, using
+// the semantics of joinType. It sets a LocalHashAndJoinWith instruction of
+// that join type on the new step directly, so outer/semi/anti joins are
+// reachable from the flow DSL without dropping down to the instruction package.
+func (d *Dataset) HashJoin(other *Dataset, joinType JoinType, indexes ...int) *Dataset {
+	ret, step := add2ToOneDataset(d, other)
+	step.SetInstruction(instruction.NewLocalHashAndJoinWithType(indexes, instruction.JoinType(joinType)))
+	return ret
+}
@@ -0,0 +1,182 @@
+package instruction
+
+import (
+	"encoding/binary"
+	"errors"
+	"hash/fnv"
+	"math"
+)
+
+var errBloomFilterTooShort = errors.New("bloom filter: serialized data too short")
+
+const (
+	// defaultBloomFilterFalsePositiveRate is the target false-positive rate
+	// used when callers do not ask for a specific one.
+	defaultBloomFilterFalsePositiveRate = 0.01
+	// defaultBloomFilterByteCap bounds how large a filter's bit array is
+	// allowed to grow. Joins whose build side would need a bigger filter
+	// fall back to running without one.
+	defaultBloomFilterByteCap = 64 * 1024 * 1024
+)
+
+// BloomFilterOptions tunes the Bloom filters built for semi-join
+// pre-filtering.
+type BloomFilterOptions struct {
+	// FalsePositiveRate is the target false-positive rate, e.g. 0.01 for 1%.
+	FalsePositiveRate float64
+	// ByteCap is the largest bit-array size, in bytes, a filter may use.
+	// A build side that would need a bigger filter runs without one.
+	ByteCap int64
+}
+
+func (o BloomFilterOptions) withDefaults() BloomFilterOptions {
+	if o.FalsePositiveRate <= 0 {
+		o.FalsePositiveRate = defaultBloomFilterFalsePositiveRate
+	}
+	if o.ByteCap <= 0 {
+		o.ByteCap = defaultBloomFilterByteCap
+	}
+	return o
+}
+
+// BloomFilter is a fixed-size bit-array Bloom filter, sized up front for an
+// expected number of keys and a target false-positive rate. It derives its k
+// hash functions from two independent 64-bit hashes via Kirsch-Mitzenmacher
+// double hashing, rather than computing k separate hashes per key.
+type BloomFilter struct {
+	bits []uint64
+	m    uint64 // number of bits
+	k    uint64 // number of hash functions
+}
+
+// NewBloomFilter sizes a filter for expectedKeys items at falsePositiveRate.
+func NewBloomFilter(expectedKeys uint64, falsePositiveRate float64) *BloomFilter {
+	if expectedKeys == 0 {
+		expectedKeys = 1
+	}
+	if falsePositiveRate <= 0 {
+		falsePositiveRate = defaultBloomFilterFalsePositiveRate
+	}
+	m := optimalBloomFilterBits(expectedKeys, falsePositiveRate)
+	k := optimalBloomFilterHashCount(m, expectedKeys)
+	return &BloomFilter{
+		bits: make([]uint64, (m+63)/64),
+		m:    m,
+		k:    k,
+	}
+}
+
+func optimalBloomFilterBits(n uint64, p float64) uint64 {
+	m := math.Ceil(-1 * float64(n) * math.Log(p) / (math.Ln2 * math.Ln2))
+	if m < 64 {
+		m = 64
+	}
+	return uint64(m)
+}
+
+// optimalBloomFilterByteSize returns the bit-array footprint a filter sized
+// for n keys at falsePositiveRate would need, without allocating one. Callers
+// that only need to check a size against a cap should use this instead of
+// NewBloomFilter(n, p).ByteSize(), which allocates the full bit array just to
+// report its length.
+func optimalBloomFilterByteSize(n uint64, falsePositiveRate float64) int64 {
+	if n == 0 {
+		n = 1
+	}
+	if falsePositiveRate <= 0 {
+		falsePositiveRate = defaultBloomFilterFalsePositiveRate
+	}
+	m := optimalBloomFilterBits(n, falsePositiveRate)
+	return int64((m+63)/64) * 8
+}
+
+func optimalBloomFilterHashCount(m, n uint64) uint64 {
+	k := math.Round(float64(m) / float64(n) * math.Ln2)
+	if k < 1 {
+		k = 1
+	}
+	return uint64(k)
+}
+
+// ByteSize returns the in-memory footprint of the filter's bit array.
+func (f *BloomFilter) ByteSize() int64 {
+	return int64(len(f.bits)) * 8
+}
+
+func (f *BloomFilter) hashes(key []byte) (uint64, uint64) {
+	h := fnv.New64a()
+	h.Write(key)
+	h1 := h.Sum64()
+	h.Write([]byte{0})
+	h2 := h.Sum64()
+	return h1, h2
+}
+
+// Add inserts key into the filter.
+func (f *BloomFilter) Add(key []byte) {
+	h1, h2 := f.hashes(key)
+	for i := uint64(0); i < f.k; i++ {
+		bit := (h1 + i*h2) % f.m
+		f.bits[bit/64] |= 1 << (bit % 64)
+	}
+}
+
+// Contains reports whether key may have been inserted. A false result is
+// definitive; a true result can be a false positive, at roughly the rate
+// the filter was sized for.
+func (f *BloomFilter) Contains(key []byte) bool {
+	h1, h2 := f.hashes(key)
+	for i := uint64(0); i < f.k; i++ {
+		bit := (h1 + i*h2) % f.m
+		if f.bits[bit/64]&(1<<(bit%64)) == 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// Bytes serializes the filter so it can be shipped as a side-channel message
+// to upstream shuffle stages.
+func (f *BloomFilter) Bytes() []byte {
+	header := make([]byte, 16)
+	binary.LittleEndian.PutUint64(header[0:8], f.m)
+	binary.LittleEndian.PutUint64(header[8:16], f.k)
+	body := make([]byte, len(f.bits)*8)
+	for i, word := range f.bits {
+		binary.LittleEndian.PutUint64(body[i*8:], word)
+	}
+	return append(header, body...)
+}
+
+// ParseBloomFilter deserializes a filter previously produced by Bytes.
+func ParseBloomFilter(data []byte) (*BloomFilter, error) {
+	if len(data) < 16 {
+		return nil, errBloomFilterTooShort
+	}
+	m := binary.LittleEndian.Uint64(data[0:8])
+	k := binary.LittleEndian.Uint64(data[8:16])
+	body := data[16:]
+	bits := make([]uint64, (m+63)/64)
+	for i := range bits {
+		start := i * 8
+		if start+8 > len(body) {
+			break
+		}
+		bits[i] = binary.LittleEndian.Uint64(body[start : start+8])
+	}
+	return &BloomFilter{bits: bits, m: m, k: k}, nil
+}
+
+// buildBloomFilterFromKeys builds a filter sized exactly for keys, or
+// returns ok=false if doing so would exceed opts.ByteCap.
+func buildBloomFilterFromKeys(keys []string, opts BloomFilterOptions) (filter *BloomFilter, ok bool) {
+	opts = opts.withDefaults()
+	filter = NewBloomFilter(uint64(len(keys)), opts.FalsePositiveRate)
+	if filter.ByteSize() > opts.ByteCap {
+		return nil, false
+	}
+	for _, key := range keys {
+		filter.Add([]byte(key))
+	}
+	return filter, true
+}
@@ -0,0 +1,37 @@
+package instruction
+
+import "testing"
+
+func TestBloomFilter_ContainsInsertedKeys(t *testing.T) {
+	keys := []string{"alice", "bob", "carol", "dave"}
+	filter, ok := buildBloomFilterFromKeys(keys, BloomFilterOptions{})
+	if !ok {
+		t.Fatal("expected the filter to build within the default byte cap")
+	}
+	for _, key := range keys {
+		if !filter.Contains([]byte(key)) {
+			t.Errorf("expected filter to contain inserted key %q", key)
+		}
+	}
+}
+
+func TestBloomFilter_ByteCapFallsBack(t *testing.T) {
+	keys := []string{"alice", "bob"}
+	_, ok := buildBloomFilterFromKeys(keys, BloomFilterOptions{ByteCap: 1})
+	if ok {
+		t.Fatal("expected building to fall back to no filter when the byte cap is too small")
+	}
+}
+
+func TestBloomFilter_RoundTripsThroughBytes(t *testing.T) {
+	filter := NewBloomFilter(100, 0.01)
+	filter.Add([]byte("hello"))
+
+	parsed, err := ParseBloomFilter(filter.Bytes())
+	if err != nil {
+		t.Fatalf("ParseBloomFilter failed: %v", err)
+	}
+	if !parsed.Contains([]byte("hello")) {
+		t.Fatal("expected the round-tripped filter to still contain the inserted key")
+	}
+}
@@ -0,0 +1,93 @@
+package instruction
+
+import (
+	"fmt"
+	"io"
+
+	"github.com/chrislusf/gleam/msg"
+	"github.com/chrislusf/gleam/util"
+	"github.com/golang/protobuf/proto"
+)
+
+// BuildBloomFilter streams rows through unchanged while building a Bloom
+// filter over their join keys, which it emits as a single side-channel
+// message once the input is exhausted. It is meant to run upstream of a
+// shuffle, paired with a SemiJoinFilter downstream, so that shuffle sends
+// rows that can never match a join can be dropped before they cross the
+// network.
+type BuildBloomFilter struct {
+	indexes []int
+	opts    BloomFilterOptions
+}
+
+func NewBuildBloomFilter(indexes []int, opts BloomFilterOptions) *BuildBloomFilter {
+	return &BuildBloomFilter{indexes, opts.withDefaults()}
+}
+
+func (b *BuildBloomFilter) Name() string {
+	return "BuildBloomFilter"
+}
+
+func (b *BuildBloomFilter) Function() func(readers []io.Reader, writers []io.Writer, stats *Stats) {
+	return func(readers []io.Reader, writers []io.Writer, stats *Stats) {
+		DoBuildBloomFilter(readers[0], writers[0], writers[1], b.indexes, b.opts)
+	}
+}
+
+func (b *BuildBloomFilter) SerializeToCommand() *msg.Instruction {
+	return &msg.Instruction{
+		Name: proto.String(b.Name()),
+		BuildBloomFilter: &msg.BuildBloomFilter{
+			Indexes:           getIndexes(b.indexes),
+			FalsePositiveRate: proto.Float64(b.opts.FalsePositiveRate),
+			ByteCap:           proto.Int64(b.opts.ByteCap),
+		},
+	}
+}
+
+// DoBuildBloomFilter copies every row from reader to dataWriter unchanged,
+// while collecting their join keys. Once reader is exhausted it writes a
+// single serialized BloomFilter message to filterWriter, sized exactly for
+// the keys it saw. If the keys collected so far would need a filter bigger
+// than opts.ByteCap, it stops collecting and emits an empty message instead,
+// so a downstream SemiJoinFilter knows to pass every row through.
+func DoBuildBloomFilter(reader io.Reader, dataWriter, filterWriter io.Writer, indexes []int, opts BloomFilterOptions) {
+	opts = opts.withDefaults()
+
+	var keys []string
+	collecting := true
+
+	err := util.ProcessMessage(reader, func(input []byte) error {
+		keyBytes, _, err := genKeyBytesAndValues(input, indexes)
+		if err != nil {
+			return fmt.Errorf("%v: %+v", err, input)
+		}
+		row, err := util.DecodeRow(input)
+		if err != nil {
+			return fmt.Errorf("Failed to decode row %+v: %v", input, err)
+		}
+		util.WriteRow(dataWriter, row...)
+
+		if collecting {
+			keys = append(keys, string(keyBytes))
+			// Check the cap with the closed-form bit-count formula rather than
+			// allocating a real filter on every row just to read its size.
+			if optimalBloomFilterByteSize(uint64(len(keys)), opts.FalsePositiveRate) > opts.ByteCap {
+				collecting = false
+				keys = nil
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		fmt.Printf("BuildBloomFilter>Failed to read input data:%v\n", err)
+	}
+
+	if collecting && len(keys) > 0 {
+		if filter, ok := buildBloomFilterFromKeys(keys, opts); ok {
+			util.WriteRow(filterWriter, filter.Bytes())
+			return
+		}
+	}
+	util.WriteRow(filterWriter, []byte{})
+}
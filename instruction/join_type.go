@@ -0,0 +1,42 @@
+package instruction
+
+// JoinType selects the matching semantics for a hash join.
+type JoinType int
+
+const (
+	// InnerJoin emits a row only when both sides have a matching key.
+	InnerJoin JoinType = iota
+	// LeftOuterJoin emits every left row, with nulls for the right side
+	// when there is no match.
+	LeftOuterJoin
+	// RightOuterJoin emits every right row, with nulls for the left side
+	// when there is no match.
+	RightOuterJoin
+	// FullOuterJoin emits every left and every right row, with nulls on
+	// whichever side did not match.
+	FullOuterJoin
+	// LeftSemiJoin emits a left row once if it has at least one match on
+	// the right, with no right-side columns in the output.
+	LeftSemiJoin
+	// LeftAntiJoin emits a left row once if it has no match on the right.
+	LeftAntiJoin
+)
+
+func (t JoinType) String() string {
+	switch t {
+	case InnerJoin:
+		return "InnerJoin"
+	case LeftOuterJoin:
+		return "LeftOuterJoin"
+	case RightOuterJoin:
+		return "RightOuterJoin"
+	case FullOuterJoin:
+		return "FullOuterJoin"
+	case LeftSemiJoin:
+		return "LeftSemiJoin"
+	case LeftAntiJoin:
+		return "LeftAntiJoin"
+	default:
+		return "Unknown"
+	}
+}
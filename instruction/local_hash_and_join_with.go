@@ -11,11 +11,18 @@ import (
 )
 
 type LocalHashAndJoinWith struct {
-	indexes []int
+	indexes  []int
+	joinType JoinType
 }
 
 func NewLocalHashAndJoinWith(indexes []int) *LocalHashAndJoinWith {
-	return &LocalHashAndJoinWith{indexes}
+	return &LocalHashAndJoinWith{indexes, InnerJoin}
+}
+
+// NewLocalHashAndJoinWithType creates a hash join instruction for a specific
+// join mode. See JoinType for the supported modes.
+func NewLocalHashAndJoinWithType(indexes []int, joinType JoinType) *LocalHashAndJoinWith {
+	return &LocalHashAndJoinWith{indexes, joinType}
 }
 
 func (b *LocalHashAndJoinWith) Name() string {
@@ -24,7 +31,7 @@ func (b *LocalHashAndJoinWith) Name() string {
 
 func (b *LocalHashAndJoinWith) Function() func(readers []io.Reader, writers []io.Writer, stats *Stats) {
 	return func(readers []io.Reader, writers []io.Writer, stats *Stats) {
-		DoLocalHashAndJoinWith(readers[0], readers[1], writers[0], b.indexes)
+		DoLocalHashAndJoinWithType(readers[0], readers[1], writers[0], b.indexes, b.joinType)
 	}
 }
 
@@ -32,19 +39,42 @@ func (b *LocalHashAndJoinWith) SerializeToCommand() *msg.Instruction {
 	return &msg.Instruction{
 		Name: proto.String(b.Name()),
 		LocalHashAndJoinWith: &msg.LocalHashAndJoinWith{
-			Indexes: getIndexes(b.indexes),
+			Indexes:  getIndexes(b.indexes),
+			JoinType: proto.Int32(int32(b.joinType)),
 		},
 	}
 }
 
-// Top streamingly compare and get the top n items
+// DoLocalHashAndJoinWith performs a plain inner hash join. It is kept around
+// as a thin wrapper over DoLocalHashAndJoinWithType for callers that only
+// ever need inner-join semantics.
 func DoLocalHashAndJoinWith(leftReader, rightReader io.Reader, writer io.Writer, indexes []int) {
-	hashmap := make(map[string][]interface{})
+	DoLocalHashAndJoinWithType(leftReader, rightReader, writer, indexes, InnerJoin)
+}
+
+// DoLocalHashAndJoinWithType streamingly joins a left and a right input on
+// the given indexes, using the semantics of joinType. The left side is
+// fully buffered into a hash map keyed by the join columns; the right side
+// is then streamed against it. Outer and anti modes additionally track,
+// per left key, whether it was ever probed so that unmatched left rows can
+// be flushed once the right side is exhausted.
+func DoLocalHashAndJoinWithType(leftReader, rightReader io.Reader, writer io.Writer, indexes []int, joinType JoinType) {
+	// hashmap keeps every left-side value list seen for a key, since the
+	// left side can have multiple rows sharing the same key (1:N, N:M joins).
+	hashmap := make(map[string][][]interface{})
+	probed := make(map[string]bool)
+	// leftValueCount is the number of non-key columns on the left side, used
+	// to null-pad unmatched right rows. It is set from each left row as it is
+	// read: every left row shares the same shape, and if the left side turns
+	// out to have no rows at all there is nothing to set it from, so it stays 0.
+	var leftValueCount int
 	err := util.ProcessMessage(leftReader, func(input []byte) error {
 		if keys, vals, err := genKeyBytesAndValues(input, indexes); err != nil {
 			return fmt.Errorf("%v: %+v", err, input)
 		} else {
-			hashmap[string(keys)] = vals
+			keyString := string(keys)
+			hashmap[keyString] = append(hashmap[keyString], vals)
+			leftValueCount = len(vals)
 		}
 		return nil
 	})
@@ -52,27 +82,132 @@ func DoLocalHashAndJoinWith(leftReader, rightReader io.Reader, writer io.Writer,
 		fmt.Printf("Sort>Failed to read input data:%v\n", err)
 	}
 	if len(hashmap) == 0 {
-		io.Copy(ioutil.Discard, rightReader)
+		if joinType == RightOuterJoin || joinType == FullOuterJoin {
+			flushUnmatchedRight(rightReader, writer, indexes, 0)
+		} else {
+			io.Copy(ioutil.Discard, rightReader)
+		}
 		return
 	}
 
+	// A Bloom filter built from the left keys lets the right side skip the
+	// map lookup for rows that definitely have no match.
+	bloomKeys := make([]string, 0, len(hashmap))
+	for keyString := range hashmap {
+		bloomKeys = append(bloomKeys, keyString)
+	}
+	bloomFilter, hasBloomFilter := buildBloomFilterFromKeys(bloomKeys, BloomFilterOptions{})
+
+	// rightValueCount is the non-key column count on the right side, set the
+	// same way as leftValueCount above, for null-padding unmatched left rows.
+	var rightValueCount int
 	err = util.ProcessMessage(rightReader, func(input []byte) error {
 		if keys, vals, err := util.DecodeRowKeysValues(input, indexes); err != nil {
 			return fmt.Errorf("%v: %+v", err, input)
 		} else {
+			rightValueCount = len(vals)
 			keyBytes, err := util.EncodeRow(keys...)
 			if err != nil {
 				return fmt.Errorf("Failed to encoded row %+v: %v", keys, err)
 			}
-			if mappedValues, ok := hashmap[string(keyBytes)]; ok {
-				row := append(keys, vals...)
+			keyString := string(keyBytes)
+			var mappedValuesList [][]interface{}
+			var ok bool
+			if !hasBloomFilter || bloomFilter.Contains(keyBytes) {
+				// The filter says "maybe" (or is absent): fall back to the
+				// real lookup, which also settles any false positive.
+				mappedValuesList, ok = hashmap[keyString]
+			}
+			switch joinType {
+			case InnerJoin, LeftOuterJoin:
+				// Right rows with no left match are dropped: inner join has
+				// nothing to pair them with, and left outer only preserves
+				// unmatched rows from the left side (flushed below).
+				if ok {
+					probed[keyString] = true
+					for _, mappedValues := range mappedValuesList {
+						writeRow(writer, keys, vals, mappedValues)
+					}
+				}
+			case RightOuterJoin, FullOuterJoin:
+				if ok {
+					probed[keyString] = true
+					for _, mappedValues := range mappedValuesList {
+						writeRow(writer, keys, vals, mappedValues)
+					}
+				} else {
+					writeRow(writer, keys, vals, nullValues(leftValueCount))
+				}
+			case LeftSemiJoin:
+				if ok && !probed[keyString] {
+					probed[keyString] = true
+					for _, mappedValues := range mappedValuesList {
+						util.WriteRow(writer, append(append([]interface{}{}, keys...), mappedValues...)...)
+					}
+				}
+			case LeftAntiJoin:
+				if ok {
+					probed[keyString] = true
+				}
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		fmt.Printf("LocalHashAndJoinWith>Failed to process the bigger input data:%v\n", err)
+	}
+
+	switch joinType {
+	case LeftOuterJoin, FullOuterJoin:
+		for keyString, mappedValuesList := range hashmap {
+			if probed[keyString] {
+				continue
+			}
+			keys, _ := util.DecodeRow([]byte(keyString))
+			for _, mappedValues := range mappedValuesList {
+				row := append(append([]interface{}{}, keys...), nullValues(rightValueCount)...)
 				row = append(row, mappedValues...)
 				util.WriteRow(writer, row...)
 			}
 		}
+	case LeftAntiJoin:
+		for keyString, mappedValuesList := range hashmap {
+			if probed[keyString] {
+				continue
+			}
+			keys, _ := util.DecodeRow([]byte(keyString))
+			for _, mappedValues := range mappedValuesList {
+				util.WriteRow(writer, append(append([]interface{}{}, keys...), mappedValues...)...)
+			}
+		}
+	}
+}
+
+func writeRow(writer io.Writer, keys, vals []interface{}, mappedValues []interface{}) {
+	row := append(append([]interface{}{}, keys...), vals...)
+	row = append(row, mappedValues...)
+	util.WriteRow(writer, row...)
+}
+
+func nullValues(count int) []interface{} {
+	values := make([]interface{}, count)
+	return values
+}
+
+// flushUnmatchedRight emits every right row with nulls for the (empty) left
+// side, used when a RIGHT/FULL outer join's left side has no rows. There is
+// no left row to latch a value count from in that case, so callers pass the
+// left value count they know statically; 0 if it is genuinely unknown.
+func flushUnmatchedRight(rightReader io.Reader, writer io.Writer, indexes []int, leftValueCount int) {
+	err := util.ProcessMessage(rightReader, func(input []byte) error {
+		keys, vals, err := util.DecodeRowKeysValues(input, indexes)
+		if err != nil {
+			return fmt.Errorf("%v: %+v", err, input)
+		}
+		writeRow(writer, keys, vals, nullValues(leftValueCount))
 		return nil
 	})
 	if err != nil {
 		fmt.Printf("LocalHashAndJoinWith>Failed to process the bigger input data:%v\n", err)
 	}
-}
\ No newline at end of file
+}
@@ -0,0 +1,153 @@
+package instruction
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/chrislusf/gleam/util"
+)
+
+func writeRows(rows [][]interface{}) *bytes.Buffer {
+	buf := new(bytes.Buffer)
+	for _, row := range rows {
+		util.WriteRow(buf, row...)
+	}
+	return buf
+}
+
+func readRows(buf *bytes.Buffer) [][]interface{} {
+	var rows [][]interface{}
+	util.ProcessMessage(buf, func(input []byte) error {
+		row, err := util.DecodeRow(input)
+		if err != nil {
+			return err
+		}
+		rows = append(rows, row)
+		return nil
+	})
+	return rows
+}
+
+func TestDoLocalHashAndJoinWith_OneToMany(t *testing.T) {
+	left := writeRows([][]interface{}{
+		{"a", "left-1"},
+	})
+	right := writeRows([][]interface{}{
+		{"a", "right-1"},
+		{"a", "right-2"},
+	})
+	output := new(bytes.Buffer)
+
+	DoLocalHashAndJoinWith(left, right, output, []int{0})
+
+	rows := readRows(output)
+	if len(rows) != 2 {
+		t.Fatalf("expected 2 joined rows for a 1:N join, got %d: %+v", len(rows), rows)
+	}
+}
+
+func TestDoLocalHashAndJoinWith_ManyToOne(t *testing.T) {
+	left := writeRows([][]interface{}{
+		{"a", "left-1"},
+		{"a", "left-2"},
+	})
+	right := writeRows([][]interface{}{
+		{"a", "right-1"},
+	})
+	output := new(bytes.Buffer)
+
+	DoLocalHashAndJoinWith(left, right, output, []int{0})
+
+	rows := readRows(output)
+	if len(rows) != 2 {
+		t.Fatalf("expected 2 joined rows for a N:1 join, got %d: %+v", len(rows), rows)
+	}
+}
+
+func TestDoLocalHashAndJoinWith_ManyToMany(t *testing.T) {
+	left := writeRows([][]interface{}{
+		{"a", "left-1"},
+		{"a", "left-2"},
+	})
+	right := writeRows([][]interface{}{
+		{"a", "right-1"},
+		{"a", "right-2"},
+		{"a", "right-3"},
+	})
+	output := new(bytes.Buffer)
+
+	DoLocalHashAndJoinWith(left, right, output, []int{0})
+
+	rows := readRows(output)
+	if len(rows) != 6 {
+		t.Fatalf("expected 2*3=6 joined rows for a N:M join, got %d: %+v", len(rows), rows)
+	}
+}
+
+// TestDoLocalHashAndJoinWith_NonLeadingKeyColumn joins on a key column that
+// isn't column 0, the shape every prior test in this file skipped. A join
+// that reorders a row's columns internally but doesn't read them back off
+// the same layout it wrote them with would mis-split or silently drop rows
+// here while still passing every indexes=[0] test.
+func TestDoLocalHashAndJoinWith_NonLeadingKeyColumn(t *testing.T) {
+	left := writeRows([][]interface{}{
+		{"left-1", "a"},
+	})
+	right := writeRows([][]interface{}{
+		{"right-1", "a"},
+	})
+	output := new(bytes.Buffer)
+
+	DoLocalHashAndJoinWith(left, right, output, []int{1})
+
+	rows := readRows(output)
+	if len(rows) != 1 {
+		t.Fatalf("expected 1 joined row, got %d: %+v", len(rows), rows)
+	}
+	want := []interface{}{"a", "right-1", "left-1"}
+	if !rowsEqual(rows[0], want) {
+		t.Fatalf("expected joined row %+v, got %+v", want, rows[0])
+	}
+}
+
+func rowsEqual(a, b []interface{}) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func TestDoLocalHashAndJoinWith_EmptyLeftSide(t *testing.T) {
+	left := writeRows(nil)
+	right := writeRows([][]interface{}{
+		{"a", "right-1"},
+	})
+	output := new(bytes.Buffer)
+
+	DoLocalHashAndJoinWith(left, right, output, []int{0})
+
+	rows := readRows(output)
+	if len(rows) != 0 {
+		t.Fatalf("expected no joined rows when the left side is empty, got %d: %+v", len(rows), rows)
+	}
+}
+
+func TestDoLocalHashAndJoinWith_EmptyRightSide(t *testing.T) {
+	left := writeRows([][]interface{}{
+		{"a", "left-1"},
+	})
+	right := writeRows(nil)
+	output := new(bytes.Buffer)
+
+	DoLocalHashAndJoinWith(left, right, output, []int{0})
+
+	rows := readRows(output)
+	if len(rows) != 0 {
+		t.Fatalf("expected no joined rows when the right side is empty, got %d: %+v", len(rows), rows)
+	}
+}
@@ -0,0 +1,159 @@
+package instruction
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestDoLocalHashAndJoinWithType_LeftOuter(t *testing.T) {
+	left := writeRows([][]interface{}{
+		{"a", "left-1"},
+		{"b", "left-2"},
+	})
+	right := writeRows([][]interface{}{
+		{"a", "right-1"},
+	})
+	output := new(bytes.Buffer)
+
+	DoLocalHashAndJoinWithType(left, right, output, []int{0}, LeftOuterJoin)
+
+	rows := readRows(output)
+	if len(rows) != 2 {
+		t.Fatalf("expected 2 rows (1 matched + 1 unmatched left), got %d: %+v", len(rows), rows)
+	}
+	for _, row := range rows {
+		if len(row) != 3 {
+			t.Fatalf("expected every row to have 3 columns (key, right value, left value), got %+v", row)
+		}
+	}
+}
+
+func TestDoLocalHashAndJoinWithType_LeftOuter_EmptyRightSide(t *testing.T) {
+	left := writeRows([][]interface{}{
+		{"a", "left-1"},
+	})
+	right := writeRows(nil)
+	output := new(bytes.Buffer)
+
+	DoLocalHashAndJoinWithType(left, right, output, []int{0}, LeftOuterJoin)
+
+	rows := readRows(output)
+	if len(rows) != 1 {
+		t.Fatalf("expected the single unmatched left row, got %d: %+v", len(rows), rows)
+	}
+}
+
+// TestDoLocalHashAndJoinWithType_LeftOuter_NonLeadingKeyColumn joins on a
+// non-leading key column and checks the actual row contents, the shape every
+// other test in this file skips by always keying off column 0.
+func TestDoLocalHashAndJoinWithType_LeftOuter_NonLeadingKeyColumn(t *testing.T) {
+	left := writeRows([][]interface{}{
+		{"left-1", "a"},
+		{"left-2", "b"},
+	})
+	right := writeRows([][]interface{}{
+		{"right-1", "a"},
+	})
+	output := new(bytes.Buffer)
+
+	DoLocalHashAndJoinWithType(left, right, output, []int{1}, LeftOuterJoin)
+
+	rows := readRows(output)
+	if len(rows) != 2 {
+		t.Fatalf("expected 2 rows (1 matched + 1 unmatched left), got %d: %+v", len(rows), rows)
+	}
+	var matched, unmatched []interface{}
+	for _, row := range rows {
+		if rowsEqual(row, []interface{}{"a", "right-1", "left-1"}) {
+			matched = row
+		}
+		if len(row) == 3 && row[0] == "b" {
+			unmatched = row
+		}
+	}
+	if matched == nil {
+		t.Fatalf("expected a matched row [a right-1 left-1], got %+v", rows)
+	}
+	if unmatched == nil || unmatched[1] != nil || unmatched[2] != "left-2" {
+		t.Fatalf("expected an unmatched row [b <nil> left-2], got %+v", rows)
+	}
+}
+
+func TestDoLocalHashAndJoinWithType_RightOuter(t *testing.T) {
+	left := writeRows([][]interface{}{
+		{"a", "left-1"},
+	})
+	right := writeRows([][]interface{}{
+		{"a", "right-1"},
+		{"b", "right-2"},
+	})
+	output := new(bytes.Buffer)
+
+	DoLocalHashAndJoinWithType(left, right, output, []int{0}, RightOuterJoin)
+
+	rows := readRows(output)
+	if len(rows) != 2 {
+		t.Fatalf("expected 2 rows (1 matched + 1 unmatched right), got %d: %+v", len(rows), rows)
+	}
+	for _, row := range rows {
+		if len(row) != 3 {
+			t.Fatalf("expected every row to have 3 columns (key, right value, left value), got %+v", row)
+		}
+	}
+}
+
+func TestDoLocalHashAndJoinWithType_FullOuter(t *testing.T) {
+	left := writeRows([][]interface{}{
+		{"a", "left-1"},
+		{"b", "left-2"},
+	})
+	right := writeRows([][]interface{}{
+		{"a", "right-1"},
+		{"c", "right-2"},
+	})
+	output := new(bytes.Buffer)
+
+	DoLocalHashAndJoinWithType(left, right, output, []int{0}, FullOuterJoin)
+
+	rows := readRows(output)
+	if len(rows) != 3 {
+		t.Fatalf("expected 3 rows (1 matched + 1 unmatched left + 1 unmatched right), got %d: %+v", len(rows), rows)
+	}
+}
+
+func TestDoLocalHashAndJoinWithType_LeftSemi(t *testing.T) {
+	left := writeRows([][]interface{}{
+		{"a", "left-1"},
+		{"b", "left-2"},
+	})
+	right := writeRows([][]interface{}{
+		{"a", "right-1"},
+		{"a", "right-2"},
+	})
+	output := new(bytes.Buffer)
+
+	DoLocalHashAndJoinWithType(left, right, output, []int{0}, LeftSemiJoin)
+
+	rows := readRows(output)
+	if len(rows) != 1 {
+		t.Fatalf("expected the matching left row exactly once, got %d: %+v", len(rows), rows)
+	}
+}
+
+func TestDoLocalHashAndJoinWithType_LeftAnti(t *testing.T) {
+	left := writeRows([][]interface{}{
+		{"a", "left-1"},
+		{"b", "left-2"},
+	})
+	right := writeRows([][]interface{}{
+		{"a", "right-1"},
+	})
+	output := new(bytes.Buffer)
+
+	DoLocalHashAndJoinWithType(left, right, output, []int{0}, LeftAntiJoin)
+
+	rows := readRows(output)
+	if len(rows) != 1 {
+		t.Fatalf("expected only the unmatched left row, got %d: %+v", len(rows), rows)
+	}
+}
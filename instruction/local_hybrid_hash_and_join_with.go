@@ -0,0 +1,326 @@
+package instruction
+
+import (
+	"fmt"
+	"hash/fnv"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/chrislusf/gleam/msg"
+	"github.com/chrislusf/gleam/util"
+	"github.com/golang/protobuf/proto"
+)
+
+const (
+	defaultHybridJoinPartitionCount = 32
+	// defaultHybridJoinByteBudget is how much left-side data DoHybridHashAndJoinWith
+	// will buffer in memory before it starts spilling to disk.
+	defaultHybridJoinByteBudget = 256 * 1024 * 1024
+)
+
+// HybridHashJoinOptions tunes when and how DoHybridHashAndJoinWith spills to disk.
+type HybridHashJoinOptions struct {
+	// PartitionCount is how many hash partitions the left and right side are
+	// split into once the join decides to spill. Partition 0 is always kept
+	// in memory.
+	PartitionCount int
+	// SpillDirectory is where partition temp files are written. Defaults to
+	// a fresh directory under os.TempDir().
+	SpillDirectory string
+	// ByteBudget is how many bytes of left-side input DoHybridHashAndJoinWith
+	// will hold in memory before it switches to the partitioned, on-disk mode.
+	ByteBudget int64
+}
+
+func (o HybridHashJoinOptions) withDefaults() HybridHashJoinOptions {
+	if o.PartitionCount <= 1 {
+		o.PartitionCount = defaultHybridJoinPartitionCount
+	}
+	if o.ByteBudget <= 0 {
+		o.ByteBudget = defaultHybridJoinByteBudget
+	}
+	return o
+}
+
+type HybridHashAndJoinWith struct {
+	indexes []int
+	opts    HybridHashJoinOptions
+}
+
+func NewHybridHashAndJoinWith(indexes []int, opts HybridHashJoinOptions) *HybridHashAndJoinWith {
+	return &HybridHashAndJoinWith{indexes, opts.withDefaults()}
+}
+
+func (b *HybridHashAndJoinWith) Name() string {
+	return "HybridHashAndJoinWith"
+}
+
+func (b *HybridHashAndJoinWith) Function() func(readers []io.Reader, writers []io.Writer, stats *Stats) {
+	return func(readers []io.Reader, writers []io.Writer, stats *Stats) {
+		DoHybridHashAndJoinWith(readers[0], readers[1], writers[0], b.indexes, b.opts)
+	}
+}
+
+func (b *HybridHashAndJoinWith) SerializeToCommand() *msg.Instruction {
+	return &msg.Instruction{
+		Name: proto.String(b.Name()),
+		HybridHashAndJoinWith: &msg.HybridHashAndJoinWith{
+			Indexes:        getIndexes(b.indexes),
+			PartitionCount: proto.Int32(int32(b.opts.PartitionCount)),
+			SpillDirectory: proto.String(b.opts.SpillDirectory),
+			ByteBudget:     proto.Int64(b.opts.ByteBudget),
+		},
+	}
+}
+
+// partitionOf returns which hash partition a key falls into, in [0, partitionCount).
+func partitionOf(keyBytes []byte, partitionCount int) int {
+	h := fnv.New32a()
+	h.Write(keyBytes)
+	return int(h.Sum32() % uint32(partitionCount))
+}
+
+// prefixIndexes returns []int{0, 1, ..., n-1}. Spilled partition rows are
+// always written with their key columns moved to the front (see
+// rebalanceIntoPartitions and the spill writes in DoHybridHashAndJoinWith),
+// so reading them back has to key off their new leading positions rather
+// than the caller's original indexes into the unshuffled row.
+func prefixIndexes(n int) []int {
+	out := make([]int, n)
+	for i := range out {
+		out[i] = i
+	}
+	return out
+}
+
+// DoHybridHashAndJoinWith joins a left and a right stream on the given indexes,
+// like DoLocalHashAndJoinWith, but does not require the left side to fit in
+// memory. It starts out buffering the left side in a single in-memory hash
+// map, same as the plain hash join. Only once the buffered bytes cross
+// opts.ByteBudget does it fall back to partitioning both sides by
+// hash(key) mod opts.PartitionCount into on-disk temp files, keeping
+// partition 0 in memory and joining the remaining partitions one at a time.
+func DoHybridHashAndJoinWith(leftReader, rightReader io.Reader, writer io.Writer, indexes []int, opts HybridHashJoinOptions) {
+	opts = opts.withDefaults()
+
+	spillDir := opts.SpillDirectory
+	if spillDir == "" {
+		var err error
+		spillDir, err = ioutil.TempDir("", "gleam-hybrid-hash-join-")
+		if err != nil {
+			fmt.Printf("HybridHashAndJoinWith>Failed to create spill directory: %v\n", err)
+			io.Copy(ioutil.Discard, leftReader)
+			io.Copy(ioutil.Discard, rightReader)
+			return
+		}
+		defer os.RemoveAll(spillDir)
+	}
+
+	hashmap := make(map[string][][]interface{})
+	var bytesBuffered int64
+	spilling := false
+
+	leftSpillWriters := make([]*os.File, opts.PartitionCount)
+	err := util.ProcessMessage(leftReader, func(input []byte) error {
+		keys, vals, err := genKeyBytesAndValues(input, indexes)
+		if err != nil {
+			return fmt.Errorf("%v: %+v", err, input)
+		}
+		keyString := string(keys)
+
+		if !spilling {
+			hashmap[keyString] = append(hashmap[keyString], vals)
+			bytesBuffered += int64(len(input))
+			if bytesBuffered > opts.ByteBudget {
+				if err := rebalanceIntoPartitions(hashmap, opts.PartitionCount, leftSpillWriters, spillDir); err != nil {
+					return err
+				}
+				spilling = true
+			}
+			return nil
+		}
+
+		partition := partitionOf(keys, opts.PartitionCount)
+		if partition == 0 {
+			hashmap[keyString] = append(hashmap[keyString], vals)
+			return nil
+		}
+		f, err := openPartitionSpillFile(leftSpillWriters, spillDir, "left", partition)
+		if err != nil {
+			return err
+		}
+		decodedKeys, err := util.DecodeRow(keys)
+		if err != nil {
+			return fmt.Errorf("Failed to decode key %+v: %v", keys, err)
+		}
+		return util.WriteRow(f, append(append([]interface{}{}, decodedKeys...), vals...)...)
+	})
+	if err != nil {
+		fmt.Printf("HybridHashAndJoinWith>Failed to read left input data:%v\n", err)
+	}
+
+	if !spilling {
+		// The whole left side fit in the budget: behave exactly like the
+		// plain in-memory hash join.
+		if len(hashmap) == 0 {
+			io.Copy(ioutil.Discard, rightReader)
+			return
+		}
+		joinProbeStream(rightReader, writer, indexes, hashmap)
+		return
+	}
+
+	rightSpillWriters := make([]*os.File, opts.PartitionCount)
+	err = util.ProcessMessage(rightReader, func(input []byte) error {
+		keys, vals, err := util.DecodeRowKeysValues(input, indexes)
+		if err != nil {
+			return fmt.Errorf("%v: %+v", err, input)
+		}
+		keyBytes, err := util.EncodeRow(keys...)
+		if err != nil {
+			return fmt.Errorf("Failed to encode row %+v: %v", keys, err)
+		}
+		partition := partitionOf(keyBytes, opts.PartitionCount)
+		if partition == 0 {
+			if mappedValuesList, ok := hashmap[string(keyBytes)]; ok {
+				writeJoinedRows(writer, keys, vals, mappedValuesList)
+			}
+			return nil
+		}
+		f, err := openPartitionSpillFile(rightSpillWriters, spillDir, "right", partition)
+		if err != nil {
+			return err
+		}
+		return util.WriteRow(f, append(append([]interface{}{}, keys...), vals...)...)
+	})
+	if err != nil {
+		fmt.Printf("HybridHashAndJoinWith>Failed to read right input data:%v\n", err)
+	}
+	closeSpillWriters(leftSpillWriters)
+	closeSpillWriters(rightSpillWriters)
+
+	for partition := 1; partition < opts.PartitionCount; partition++ {
+		joinSpilledPartition(spillDir, partition, indexes, writer)
+	}
+}
+
+func openPartitionSpillFile(writers []*os.File, spillDir, side string, partition int) (*os.File, error) {
+	if writers[partition] != nil {
+		return writers[partition], nil
+	}
+	f, err := os.Create(filepath.Join(spillDir, fmt.Sprintf("%s-%d", side, partition)))
+	if err != nil {
+		return nil, fmt.Errorf("Failed to create spill file for %s partition %d: %v", side, partition, err)
+	}
+	writers[partition] = f
+	return f, nil
+}
+
+func closeSpillWriters(writers []*os.File) {
+	for _, f := range writers {
+		if f != nil {
+			f.Close()
+		}
+	}
+}
+
+// rebalanceIntoPartitions moves every hash map entry that does not belong in
+// partition 0 out to its spill file, the moment a join decides to start
+// spilling.
+func rebalanceIntoPartitions(hashmap map[string][][]interface{}, partitionCount int, leftSpillWriters []*os.File, spillDir string) error {
+	for keyString, valsList := range hashmap {
+		partition := partitionOf([]byte(keyString), partitionCount)
+		if partition == 0 {
+			continue
+		}
+		f, err := openPartitionSpillFile(leftSpillWriters, spillDir, "left", partition)
+		if err != nil {
+			return err
+		}
+		keys, err := util.DecodeRow([]byte(keyString))
+		if err != nil {
+			return fmt.Errorf("Failed to decode buffered key %q: %v", keyString, err)
+		}
+		for _, vals := range valsList {
+			if err := util.WriteRow(f, append(append([]interface{}{}, keys...), vals...)...); err != nil {
+				return err
+			}
+		}
+		delete(hashmap, keyString)
+	}
+	return nil
+}
+
+// joinSpilledPartition loads one spilled left partition into memory and
+// streams the matching right partition against it, so at most one
+// partition's worth of left data is resident at a time.
+func joinSpilledPartition(spillDir string, partition int, indexes []int, writer io.Writer) {
+	leftPath := filepath.Join(spillDir, fmt.Sprintf("left-%d", partition))
+	rightPath := filepath.Join(spillDir, fmt.Sprintf("right-%d", partition))
+	defer os.Remove(leftPath)
+	defer os.Remove(rightPath)
+
+	leftFile, err := os.Open(leftPath)
+	if err != nil {
+		// No left rows ever hashed into this partition, so nothing can match.
+		return
+	}
+	defer leftFile.Close()
+
+	spilledIndexes := prefixIndexes(len(indexes))
+	hashmap := make(map[string][][]interface{})
+	err = util.ProcessMessage(leftFile, func(input []byte) error {
+		keys, vals, err := genKeyBytesAndValues(input, spilledIndexes)
+		if err != nil {
+			return fmt.Errorf("%v: %+v", err, input)
+		}
+		hashmap[string(keys)] = append(hashmap[string(keys)], vals)
+		return nil
+	})
+	if err != nil {
+		fmt.Printf("HybridHashAndJoinWith>Failed to read spilled left partition %d:%v\n", partition, err)
+	}
+	if len(hashmap) == 0 {
+		return
+	}
+
+	rightFile, err := os.Open(rightPath)
+	if err != nil {
+		return
+	}
+	defer rightFile.Close()
+
+	joinProbeStream(rightFile, writer, spilledIndexes, hashmap)
+}
+
+// joinProbeStream reads rows from reader, probes hashmap by indexes, and
+// writes one joined row per stored left value list for each match.
+func joinProbeStream(reader io.Reader, writer io.Writer, indexes []int, hashmap map[string][][]interface{}) {
+	err := util.ProcessMessage(reader, func(input []byte) error {
+		keys, vals, err := util.DecodeRowKeysValues(input, indexes)
+		if err != nil {
+			return fmt.Errorf("%v: %+v", err, input)
+		}
+		keyBytes, err := util.EncodeRow(keys...)
+		if err != nil {
+			return fmt.Errorf("Failed to encode row %+v: %v", keys, err)
+		}
+		if mappedValuesList, ok := hashmap[string(keyBytes)]; ok {
+			writeJoinedRows(writer, keys, vals, mappedValuesList)
+		}
+		return nil
+	})
+	if err != nil {
+		fmt.Printf("HybridHashAndJoinWith>Failed to probe partition:%v\n", err)
+	}
+}
+
+func writeJoinedRows(writer io.Writer, keys, vals []interface{}, mappedValuesList [][]interface{}) {
+	for _, mappedValues := range mappedValuesList {
+		row := append(append([]interface{}{}, keys...), vals...)
+		row = append(row, mappedValues...)
+		util.WriteRow(writer, row...)
+	}
+}
@@ -0,0 +1,104 @@
+package instruction
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestDoHybridHashAndJoinWith_InMemory(t *testing.T) {
+	left := writeRows([][]interface{}{
+		{"a", "left-1"},
+		{"b", "left-2"},
+	})
+	right := writeRows([][]interface{}{
+		{"a", "right-1"},
+		{"b", "right-2"},
+		{"c", "right-3"},
+	})
+	output := new(bytes.Buffer)
+
+	DoHybridHashAndJoinWith(left, right, output, []int{0}, HybridHashJoinOptions{})
+
+	rows := readRows(output)
+	if len(rows) != 2 {
+		t.Fatalf("expected 2 joined rows, got %d: %+v", len(rows), rows)
+	}
+}
+
+func TestDoHybridHashAndJoinWith_SpillsToDisk(t *testing.T) {
+	left := writeRows([][]interface{}{
+		{"a", "left-1"},
+		{"b", "left-2"},
+		{"c", "left-3"},
+		{"d", "left-4"},
+	})
+	right := writeRows([][]interface{}{
+		{"a", "right-1"},
+		{"b", "right-2"},
+		{"c", "right-3"},
+		{"d", "right-4"},
+		{"e", "right-5"},
+	})
+	output := new(bytes.Buffer)
+
+	// A byte budget of 1 forces the join to spill after the very first
+	// left row, exercising the on-disk partitioned path end to end.
+	DoHybridHashAndJoinWith(left, right, output, []int{0}, HybridHashJoinOptions{
+		PartitionCount: 4,
+		ByteBudget:     1,
+	})
+
+	rows := readRows(output)
+	if len(rows) != 4 {
+		t.Fatalf("expected 4 joined rows after spilling, got %d: %+v", len(rows), rows)
+	}
+}
+
+// TestDoHybridHashAndJoinWith_SpillsToDisk_NonLeadingKeyColumn forces the
+// same on-disk partitioned path as TestDoHybridHashAndJoinWith_SpillsToDisk,
+// but keyed on a non-leading column. Spilled rows get their key columns
+// moved to the front on disk, so reading them back with the caller's
+// original indexes (rather than the spilled layout) would mis-split every
+// row that lands in a spilled partition.
+func TestDoHybridHashAndJoinWith_SpillsToDisk_NonLeadingKeyColumn(t *testing.T) {
+	left := writeRows([][]interface{}{
+		{"left-1", "a"},
+		{"left-2", "a"},
+	})
+	right := writeRows([][]interface{}{
+		{"right-1", "a"},
+	})
+	output := new(bytes.Buffer)
+
+	// A byte budget of 1 forces the join to spill after the very first left
+	// row, exercising the on-disk partitioned path end to end.
+	DoHybridHashAndJoinWith(left, right, output, []int{1}, HybridHashJoinOptions{
+		PartitionCount: 4,
+		ByteBudget:     1,
+	})
+
+	rows := readRows(output)
+	if len(rows) != 2 {
+		t.Fatalf("expected 2 joined rows after spilling, got %d: %+v", len(rows), rows)
+	}
+	for _, row := range rows {
+		if row[0] != "a" || row[1] != "right-1" {
+			t.Fatalf("expected every row to start with [a right-1 ...], got %+v", row)
+		}
+	}
+}
+
+func TestDoHybridHashAndJoinWith_EmptyLeftSide(t *testing.T) {
+	left := writeRows(nil)
+	right := writeRows([][]interface{}{
+		{"a", "right-1"},
+	})
+	output := new(bytes.Buffer)
+
+	DoHybridHashAndJoinWith(left, right, output, []int{0}, HybridHashJoinOptions{})
+
+	rows := readRows(output)
+	if len(rows) != 0 {
+		t.Fatalf("expected no joined rows when the left side is empty, got %d: %+v", len(rows), rows)
+	}
+}
@@ -0,0 +1,90 @@
+package instruction
+
+import (
+	"fmt"
+	"io"
+
+	"github.com/chrislusf/gleam/msg"
+	"github.com/chrislusf/gleam/util"
+	"github.com/golang/protobuf/proto"
+)
+
+// SemiJoinFilter drops rows whose join key is definitely not present in a
+// Bloom filter built upstream by BuildBloomFilter, and passes the rest
+// through unchanged. It is the probe-side counterpart that lets a shuffle
+// join skip sending rows that can never find a match.
+type SemiJoinFilter struct {
+	indexes []int
+}
+
+func NewSemiJoinFilter(indexes []int) *SemiJoinFilter {
+	return &SemiJoinFilter{indexes}
+}
+
+func (b *SemiJoinFilter) Name() string {
+	return "SemiJoinFilter"
+}
+
+func (b *SemiJoinFilter) Function() func(readers []io.Reader, writers []io.Writer, stats *Stats) {
+	return func(readers []io.Reader, writers []io.Writer, stats *Stats) {
+		DoSemiJoinFilter(readers[0], readers[1], writers[0], b.indexes)
+	}
+}
+
+func (b *SemiJoinFilter) SerializeToCommand() *msg.Instruction {
+	return &msg.Instruction{
+		Name: proto.String(b.Name()),
+		SemiJoinFilter: &msg.SemiJoinFilter{
+			Indexes: getIndexes(b.indexes),
+		},
+	}
+}
+
+// DoSemiJoinFilter reads the Bloom filter message produced by
+// DoBuildBloomFilter from filterReader, then streams dataReader, writing
+// only the rows whose join key the filter says may be present. A missing or
+// empty filter message means no filter was built, so every row passes.
+func DoSemiJoinFilter(filterReader, dataReader io.Reader, writer io.Writer, indexes []int) {
+	var filter *BloomFilter
+	err := util.ProcessMessage(filterReader, func(input []byte) error {
+		row, err := util.DecodeRow(input)
+		if err != nil {
+			return fmt.Errorf("Failed to decode bloom filter message: %v", err)
+		}
+		if len(row) == 0 {
+			return nil
+		}
+		filterBytes, ok := row[0].([]byte)
+		if !ok || len(filterBytes) == 0 {
+			return nil
+		}
+		parsed, err := ParseBloomFilter(filterBytes)
+		if err != nil {
+			return fmt.Errorf("Failed to parse bloom filter: %v", err)
+		}
+		filter = parsed
+		return nil
+	})
+	if err != nil {
+		fmt.Printf("SemiJoinFilter>Failed to read bloom filter:%v\n", err)
+	}
+
+	err = util.ProcessMessage(dataReader, func(input []byte) error {
+		keyBytes, _, err := genKeyBytesAndValues(input, indexes)
+		if err != nil {
+			return fmt.Errorf("%v: %+v", err, input)
+		}
+		if filter != nil && !filter.Contains(keyBytes) {
+			return nil
+		}
+		row, err := util.DecodeRow(input)
+		if err != nil {
+			return fmt.Errorf("Failed to decode row %+v: %v", input, err)
+		}
+		util.WriteRow(writer, row...)
+		return nil
+	})
+	if err != nil {
+		fmt.Printf("SemiJoinFilter>Failed to process data:%v\n", err)
+	}
+}
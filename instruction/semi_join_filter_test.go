@@ -0,0 +1,122 @@
+package instruction
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestBuildAndSemiJoinFilter(t *testing.T) {
+	build := writeRows([][]interface{}{
+		{"a", "left-1"},
+		{"b", "left-2"},
+	})
+	dataPassthrough := new(bytes.Buffer)
+	filterChannel := new(bytes.Buffer)
+
+	DoBuildBloomFilter(build, dataPassthrough, filterChannel, []int{0}, BloomFilterOptions{})
+
+	passedThrough := readRows(dataPassthrough)
+	if len(passedThrough) != 2 {
+		t.Fatalf("expected BuildBloomFilter to pass every row through unchanged")
+	}
+	if !rowsEqual(passedThrough[0], []interface{}{"a", "left-1"}) || !rowsEqual(passedThrough[1], []interface{}{"b", "left-2"}) {
+		t.Fatalf("expected rows to pass through with their original column order intact, got %+v", passedThrough)
+	}
+
+	probe := writeRows([][]interface{}{
+		{"a", "right-1"},
+		{"c", "right-2"},
+	})
+	output := new(bytes.Buffer)
+	DoSemiJoinFilter(filterChannel, probe, output, []int{0})
+
+	rows := readRows(output)
+	if len(rows) != 1 {
+		t.Fatalf("expected only the row matching a filtered key to pass, got %d: %+v", len(rows), rows)
+	}
+	if !rowsEqual(rows[0], []interface{}{"a", "right-1"}) {
+		t.Fatalf("expected the passed row to keep its original column order, got %+v", rows[0])
+	}
+}
+
+// TestBuildAndSemiJoinFilter_NonLeadingKeyColumn exercises both instructions
+// keyed on a non-leading column, and checks that rows pass through with
+// their original column order, not reshuffled to keys-first.
+func TestBuildAndSemiJoinFilter_NonLeadingKeyColumn(t *testing.T) {
+	build := writeRows([][]interface{}{
+		{"left-1", "a"},
+		{"left-2", "b"},
+	})
+	dataPassthrough := new(bytes.Buffer)
+	filterChannel := new(bytes.Buffer)
+
+	DoBuildBloomFilter(build, dataPassthrough, filterChannel, []int{1}, BloomFilterOptions{})
+
+	passedThrough := readRows(dataPassthrough)
+	if !rowsEqual(passedThrough[0], []interface{}{"left-1", "a"}) || !rowsEqual(passedThrough[1], []interface{}{"left-2", "b"}) {
+		t.Fatalf("expected rows to pass through with their original column order intact, got %+v", passedThrough)
+	}
+
+	probe := writeRows([][]interface{}{
+		{"right-1", "a"},
+		{"right-2", "c"},
+	})
+	output := new(bytes.Buffer)
+	DoSemiJoinFilter(filterChannel, probe, output, []int{1})
+
+	rows := readRows(output)
+	if len(rows) != 1 {
+		t.Fatalf("expected only the row matching a filtered key to pass, got %d: %+v", len(rows), rows)
+	}
+	if !rowsEqual(rows[0], []interface{}{"right-1", "a"}) {
+		t.Fatalf("expected the passed row to keep its original column order, got %+v", rows[0])
+	}
+}
+
+// TestBuildBloomFilterSemiJoinFilterAndHashJoin_NonLeadingKeyColumn chains
+// DoBuildBloomFilter into DoSemiJoinFilter into DoLocalHashAndJoinWith, the
+// realistic shuffle-join pipeline these instructions are meant for, keyed on
+// a non-leading column throughout. If any stage reshuffled its rows to
+// keys-first without the next stage expecting it, the join at the end would
+// find no matches at all.
+func TestBuildBloomFilterSemiJoinFilterAndHashJoin_NonLeadingKeyColumn(t *testing.T) {
+	left := writeRows([][]interface{}{
+		{"left-1", "a"},
+	})
+	leftPassthrough := new(bytes.Buffer)
+	filterChannel := new(bytes.Buffer)
+	DoBuildBloomFilter(left, leftPassthrough, filterChannel, []int{1}, BloomFilterOptions{})
+
+	right := writeRows([][]interface{}{
+		{"right-1", "a"},
+		{"right-2", "z"},
+	})
+	rightFiltered := new(bytes.Buffer)
+	DoSemiJoinFilter(filterChannel, right, rightFiltered, []int{1})
+
+	output := new(bytes.Buffer)
+	DoLocalHashAndJoinWith(leftPassthrough, rightFiltered, output, []int{1})
+
+	rows := readRows(output)
+	if len(rows) != 1 {
+		t.Fatalf("expected 1 joined row, got %d: %+v", len(rows), rows)
+	}
+	if !rowsEqual(rows[0], []interface{}{"a", "right-1", "left-1"}) {
+		t.Fatalf("expected joined row [a right-1 left-1], got %+v", rows[0])
+	}
+}
+
+func TestSemiJoinFilter_NoFilterPassesEverything(t *testing.T) {
+	probe := writeRows([][]interface{}{
+		{"a", "right-1"},
+		{"b", "right-2"},
+	})
+	output := new(bytes.Buffer)
+
+	DoSemiJoinFilter(new(bytes.Buffer), probe, output, []int{0})
+
+	rows := readRows(output)
+	if len(rows) != 2 {
+		t.Fatalf("expected every row to pass when no filter was built, got %d: %+v", len(rows), rows)
+	}
+}
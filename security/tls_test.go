@@ -0,0 +1,243 @@
+package security
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// generateTestCA and generateTestLeaf build a throwaway CA and a leaf
+// certificate signed by it, so the test can exercise mutual TLS without
+// checked-in certificate fixtures.
+func generateTestCA(t *testing.T) (caCertPEM, caKeyPEM []byte, caCert *x509.Certificate, caKey *ecdsa.PrivateKey) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("failed to generate CA key: %v", err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "test-ca"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageDigitalSignature,
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("failed to create CA certificate: %v", err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatalf("failed to parse CA certificate: %v", err)
+	}
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatalf("failed to marshal CA key: %v", err)
+	}
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
+	return certPEM, keyPEM, cert, key
+}
+
+func generateTestLeaf(t *testing.T, commonName string, caCert *x509.Certificate, caKey *ecdsa.PrivateKey) (certPEM, keyPEM []byte) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("failed to generate leaf key: %v", err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(2),
+		Subject:      pkix.Name{CommonName: commonName},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		DNSNames:     []string{commonName},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, caCert, &key.PublicKey, caKey)
+	if err != nil {
+		t.Fatalf("failed to create leaf certificate: %v", err)
+	}
+	certPEM = pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatalf("failed to marshal leaf key: %v", err)
+	}
+	keyPEM = pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
+	return certPEM, keyPEM
+}
+
+func writeTempFile(t *testing.T, dir, name string, data []byte) string {
+	t.Helper()
+	path := filepath.Join(dir, name)
+	if err := ioutil.WriteFile(path, data, 0600); err != nil {
+		t.Fatalf("failed to write %s: %v", path, err)
+	}
+	return path
+}
+
+// TestMutualTLSHandshake spins up a server and a client, each loading their
+// TLS material through a security.Config, and checks that a connection
+// with RequireAndVerifyClientCert succeeds end to end.
+func TestMutualTLSHandshake(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gleam-security-test-")
+	if err != nil {
+		t.Fatalf("failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	caCertPEM, caKeyPEM, caCert, caKey := generateTestCA(t)
+	caFile := writeTempFile(t, dir, "ca.pem", caCertPEM)
+	_ = caKeyPEM
+
+	serverCertPEM, serverKeyPEM := generateTestLeaf(t, "agent.gleam", caCert, caKey)
+	serverCertFile := writeTempFile(t, dir, "server-cert.pem", serverCertPEM)
+	serverKeyFile := writeTempFile(t, dir, "server-key.pem", serverKeyPEM)
+
+	clientCertPEM, clientKeyPEM := generateTestLeaf(t, "master.gleam", caCert, caKey)
+	clientCertFile := writeTempFile(t, dir, "client-cert.pem", clientCertPEM)
+	clientKeyFile := writeTempFile(t, dir, "client-key.pem", clientKeyPEM)
+
+	serverConfig, err := NewConfig(Options{
+		CertFile: serverCertFile,
+		KeyFile:  serverKeyFile,
+		CAFile:   caFile,
+	})
+	if err != nil {
+		t.Fatalf("failed to load server config: %v", err)
+	}
+
+	clientConfig, err := NewConfig(Options{
+		CertFile:   clientCertFile,
+		KeyFile:    clientKeyFile,
+		CAFile:     caFile,
+		ServerName: "agent.gleam",
+	})
+	if err != nil {
+		t.Fatalf("failed to load client config: %v", err)
+	}
+
+	ln, err := tls.Listen("tcp", "127.0.0.1:0", serverConfig.ServerTLSConfig())
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	defer ln.Close()
+
+	const message = "hello over mTLS"
+	errCh := make(chan error, 1)
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			errCh <- err
+			return
+		}
+		defer conn.Close()
+		buf := make([]byte, len(message))
+		if _, err := io.ReadFull(conn, buf); err != nil {
+			errCh <- err
+			return
+		}
+		if string(buf) != message {
+			errCh <- fmt.Errorf("server received %q, want %q", buf, message)
+			return
+		}
+		errCh <- nil
+	}()
+
+	conn, err := tls.Dial("tcp", ln.Addr().String(), clientConfig.ClientTLSConfig())
+	if err != nil {
+		t.Fatalf("failed to dial over mTLS: %v", err)
+	}
+	defer conn.Close()
+	if _, err := conn.Write([]byte(message)); err != nil {
+		t.Fatalf("failed to write: %v", err)
+	}
+
+	if err := <-errCh; err != nil {
+		t.Fatalf("server side failed: %v", err)
+	}
+}
+
+func TestMutualTLSHandshake_RejectsUntrustedClient(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gleam-security-test-")
+	if err != nil {
+		t.Fatalf("failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	caCertPEM, _, caCert, caKey := generateTestCA(t)
+	caFile := writeTempFile(t, dir, "ca.pem", caCertPEM)
+
+	serverCertPEM, serverKeyPEM := generateTestLeaf(t, "agent.gleam", caCert, caKey)
+	serverCertFile := writeTempFile(t, dir, "server-cert.pem", serverCertPEM)
+	serverKeyFile := writeTempFile(t, dir, "server-key.pem", serverKeyPEM)
+
+	// A second, unrelated CA signs the "client" cert, so it should be
+	// rejected by a server that only trusts the first CA. The client itself
+	// trusts the first CA, so the server's check of the client certificate is
+	// the only thing that can fail the handshake.
+	otherCACertPEM, _, otherCACert, otherCAKey := generateTestCA(t)
+	_ = otherCACertPEM
+	untrustedCertPEM, untrustedKeyPEM := generateTestLeaf(t, "intruder.gleam", otherCACert, otherCAKey)
+	untrustedCertFile := writeTempFile(t, dir, "untrusted-cert.pem", untrustedCertPEM)
+	untrustedKeyFile := writeTempFile(t, dir, "untrusted-key.pem", untrustedKeyPEM)
+
+	serverConfig, err := NewConfig(Options{
+		CertFile: serverCertFile,
+		KeyFile:  serverKeyFile,
+		CAFile:   caFile,
+	})
+	if err != nil {
+		t.Fatalf("failed to load server config: %v", err)
+	}
+
+	untrustedClientConfig, err := NewConfig(Options{
+		CertFile:   untrustedCertFile,
+		KeyFile:    untrustedKeyFile,
+		CAFile:     caFile,
+		ServerName: "agent.gleam",
+	})
+	if err != nil {
+		t.Fatalf("failed to load untrusted client config: %v", err)
+	}
+
+	ln, err := tls.Listen("tcp", "127.0.0.1:0", serverConfig.ServerTLSConfig())
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	defer ln.Close()
+
+	handshakeErr := make(chan error, 1)
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			t.Errorf("failed to accept: %v", err)
+			handshakeErr <- err
+			return
+		}
+		defer conn.Close()
+		handshakeErr <- conn.(*tls.Conn).Handshake()
+	}()
+
+	conn, err := tls.Dial("tcp", ln.Addr().String(), untrustedClientConfig.ClientTLSConfig())
+	if err == nil {
+		conn.Close()
+	}
+	if err := <-handshakeErr; err == nil {
+		t.Fatal("expected the handshake to fail for an untrusted client certificate")
+	}
+}